@@ -0,0 +1,38 @@
+// Package zap adapts uber-go/zap to network.Logger, so servers that run
+// many sessions can get structured JSON logs instead of the plain-text
+// lines the default logger produces.
+package zap
+
+import (
+	"go.uber.org/zap"
+
+	"github.com/chrislonng/starx/network"
+)
+
+// Logger wraps a *zap.SugaredLogger as a network.Logger.
+type Logger struct {
+	sugar *zap.SugaredLogger
+}
+
+// New builds a Logger backed by a production zap configuration. Pass a
+// custom *zap.Logger via NewWithLogger if different encoding/output is
+// required.
+func New() (*Logger, error) {
+	l, err := zap.NewProduction()
+	if err != nil {
+		return nil, err
+	}
+	return NewWithLogger(l), nil
+}
+
+// NewWithLogger wraps an already-configured *zap.Logger.
+func NewWithLogger(l *zap.Logger) *Logger {
+	return &Logger{sugar: l.Sugar()}
+}
+
+func (l *Logger) Debug(msg string, kv ...interface{}) { l.sugar.Debugw(msg, kv...) }
+func (l *Logger) Info(msg string, kv ...interface{})  { l.sugar.Infow(msg, kv...) }
+func (l *Logger) Warn(msg string, kv ...interface{})  { l.sugar.Warnw(msg, kv...) }
+func (l *Logger) Error(msg string, kv ...interface{}) { l.sugar.Errorw(msg, kv...) }
+
+var _ network.Logger = (*Logger)(nil)
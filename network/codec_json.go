@@ -0,0 +1,24 @@
+package network
+
+import "encoding/json"
+
+// JsonCodec is the default Codec, it simply wraps encoding/json.
+type JsonCodec struct{}
+
+func (c *JsonCodec) Name() string {
+	return "json"
+}
+
+// ID is the content-type byte PushObject/ResponseObject prepend to a
+// JSON-encoded frame.
+func (c *JsonCodec) ID() byte {
+	return 0x00
+}
+
+func (c *JsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (c *JsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
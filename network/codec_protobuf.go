@@ -0,0 +1,39 @@
+package network
+
+import (
+	"errors"
+
+	"github.com/golang/protobuf/proto"
+)
+
+var ErrNotProtoMessage = errors.New("network: value does not implement proto.Message")
+
+// ProtobufCodec marshals any proto.Message into its binary wire format,
+// for clients that declared "protobuf" during handshake negotiation.
+type ProtobufCodec struct{}
+
+func (c *ProtobufCodec) Name() string {
+	return "protobuf"
+}
+
+// ID is the content-type byte PushObject/ResponseObject prepend to a
+// protobuf-encoded frame.
+func (c *ProtobufCodec) ID() byte {
+	return 0x01
+}
+
+func (c *ProtobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, ErrNotProtoMessage
+	}
+	return proto.Marshal(m)
+}
+
+func (c *ProtobufCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return ErrNotProtoMessage
+	}
+	return proto.Unmarshal(data, m)
+}
@@ -0,0 +1,87 @@
+package network
+
+import "testing"
+
+func TestSetAndForgetSessionCodec(t *testing.T) {
+	net := NewNetService()
+	const sid = uint64(42)
+	net.setSessionCodec(sid, "protobuf")
+	net.sessionCodecLock.RLock()
+	name, ok := net.sessionCodec[sid]
+	net.sessionCodecLock.RUnlock()
+	if !ok || name != "protobuf" {
+		t.Fatalf("setSessionCodec(%d, protobuf): got %q, %v", sid, name, ok)
+	}
+
+	net.forgetSessionCodec(sid)
+	net.sessionCodecLock.RLock()
+	_, ok = net.sessionCodec[sid]
+	net.sessionCodecLock.RUnlock()
+	if ok {
+		t.Fatalf("forgetSessionCodec(%d) left an entry behind", sid)
+	}
+}
+
+// TestSessionCodecScopedPerInstance guards against the codec negotiated
+// on one netService leaking into another: two independently constructed
+// netServices reuse the same small session ids, so sessionCodec must be
+// an instance field, not a package-level map.
+func TestSessionCodecScopedPerInstance(t *testing.T) {
+	a := NewNetService()
+	b := NewNetService()
+	const sid = uint64(1)
+
+	a.setSessionCodec(sid, "protobuf")
+
+	a.sessionCodecLock.RLock()
+	aName := a.sessionCodec[sid]
+	a.sessionCodecLock.RUnlock()
+	b.sessionCodecLock.RLock()
+	_, bOk := b.sessionCodec[sid]
+	b.sessionCodecLock.RUnlock()
+
+	if aName != "protobuf" {
+		t.Fatalf("a.sessionCodec[%d] = %q, want protobuf", sid, aName)
+	}
+	if bOk {
+		t.Fatalf("setSessionCodec on a leaked into b's sessionCodec for id %d", sid)
+	}
+}
+
+func TestGetCodecUnregistered(t *testing.T) {
+	if _, err := getCodec("does-not-exist"); err != ErrCodecNotRegistered {
+		t.Fatalf("getCodec(unregistered) = %v, want ErrCodecNotRegistered", err)
+	}
+}
+
+func TestNegotiateCodecRejectsUnregisteredName(t *testing.T) {
+	net := NewNetService()
+	const sid = uint64(7)
+	if err := net.NegotiateCodec(sid, "does-not-exist"); err != ErrCodecNotRegistered {
+		t.Fatalf("NegotiateCodec(unregistered) = %v, want ErrCodecNotRegistered", err)
+	}
+	net.sessionCodecLock.RLock()
+	_, ok := net.sessionCodec[sid]
+	net.sessionCodecLock.RUnlock()
+	if ok {
+		t.Fatalf("NegotiateCodec(unregistered) recorded a codec for session %d, want none", sid)
+	}
+
+	if err := net.NegotiateCodec(sid, "protobuf"); err != nil {
+		t.Fatalf("NegotiateCodec(protobuf) = %v, want nil", err)
+	}
+	net.sessionCodecLock.RLock()
+	name := net.sessionCodec[sid]
+	net.sessionCodecLock.RUnlock()
+	if name != "protobuf" {
+		t.Fatalf("sessionCodec[%d] = %q, want protobuf", sid, name)
+	}
+}
+
+func TestWithContentTypePrependsCodecID(t *testing.T) {
+	c := &ProtobufCodec{}
+	got := withContentType(c, []byte("payload"))
+	if got[0] != c.ID() || string(got[1:]) != "payload" {
+		t.Fatalf("withContentType = %v, want [%d payload...]", got, c.ID())
+	}
+}
@@ -0,0 +1,150 @@
+package network
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/chrislonng/starx/session"
+)
+
+var ErrGroupNotExists = errors.New("group not exists")
+
+// Group represents a named collection of sessions, e.g. a chat room or a
+// game match. Unlike Broadcast/Multicast, a Group keeps membership state
+// so application code does not have to track session ids itself.
+//
+// Cross-node fan-out is NOT implemented yet: Group.Broadcast only reaches
+// members whose session lives on this Group's own netService. A member
+// attached to another frontend in the cluster has its own same-named
+// Group tracking its own local members, and will not receive the
+// broadcast until a Group.Broadcast RPC frame exists on the
+// acceptor/master connection; see forwardGroupBroadcast. Treat
+// multi-frontend group delivery as a follow-up, not something this
+// package provides today.
+type Group struct {
+	mu       sync.RWMutex
+	name     string
+	net      *netService                 // owning instance, pushes route through this, not defaultNetService
+	sessions map[uint64]*session.Session // session id -> session
+}
+
+func newGroup(net *netService, name string) *Group {
+	return &Group{
+		name:     name,
+		net:      net,
+		sessions: make(map[uint64]*session.Session),
+	}
+}
+
+// Add adds a session to the group, it is safe to call Add more than once
+// for the same session.
+func (g *Group) Add(s *session.Session) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.sessions[s.Entity.ID()] = s
+}
+
+// Leave removes a session from the group.
+func (g *Group) Leave(s *session.Session) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.sessions, s.Entity.ID())
+}
+
+// Members returns the session ids currently in the group.
+func (g *Group) Members() []uint64 {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	members := make([]uint64, 0, len(g.sessions))
+	for sid := range g.sessions {
+		members = append(members, sid)
+	}
+	return members
+}
+
+// Broadcast pushes route/data to every member of the group attached to
+// THIS Group's own netService only. Members attached to other frontends
+// in the cluster are not reached: cross-node fan-out requires a
+// Group.Broadcast RPC frame on the acceptor/master connection, which does
+// not exist yet (see forwardGroupBroadcast). Callers running more than
+// one frontend must not rely on Broadcast for cluster-wide delivery until
+// that lands.
+func (g *Group) Broadcast(route string, data []byte) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	for _, s := range g.sessions {
+		g.net.Push(s, route, data)
+	}
+	g.net.forwardGroupBroadcast(g.name, route, data)
+}
+
+// Multicast pushes route/data to the members of the group selected by
+// filter.
+func (g *Group) Multicast(filter func(*session.Session) bool, route string, data []byte) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	for _, s := range g.sessions {
+		if filter == nil || filter(s) {
+			g.net.Push(s, route, data)
+		}
+	}
+}
+
+// NewGroup creates and registers a new Group under the given name. It is
+// an error to create two groups with the same name.
+func (net *netService) NewGroup(name string) (*Group, error) {
+	net.groupMapLock.Lock()
+	defer net.groupMapLock.Unlock()
+	if _, ok := net.groupMap[name]; ok {
+		return nil, errors.New("group: " + name + " already exists")
+	}
+	g := newGroup(net, name)
+	net.groupMap[name] = g
+	return g, nil
+}
+
+// Group looks up a previously created group by name.
+func (net *netService) Group(name string) (*Group, error) {
+	net.groupMapLock.RLock()
+	defer net.groupMapLock.RUnlock()
+	if g, ok := net.groupMap[name]; ok {
+		return g, nil
+	}
+	return nil, ErrGroupNotExists
+}
+
+// removeSessionFromGroups removes a closed session from every group it
+// belonged to, called from closeSession.
+func (net *netService) removeSessionFromGroups(s *session.Session) {
+	net.groupMapLock.RLock()
+	defer net.groupMapLock.RUnlock()
+	for _, g := range net.groupMap {
+		g.Leave(s)
+	}
+}
+
+// forwardGroupBroadcast is the seam where a group broadcast that
+// originated on this node would be forwarded to every other frontend
+// server in the cluster, so members attached to those nodes receive it
+// too. This is a known, unimplemented follow-up, not a hidden gap: there
+// is no acceptor-side dispatcher for a Group.Broadcast RPC frame yet
+// (resolving such a frame requires the frontend-peer connection type and
+// its read loop, neither of which exist in this chunk of the tree), so
+// whenever other frontends are present this logs a warning that the
+// broadcast did not reach them, instead of silently dropping it.
+//
+// Follow-up: once a Group.Broadcast RPC frame (route + payload + group
+// name) exists on the connection to peer frontends, send it there
+// instead of logging.
+func (net *netService) forwardGroupBroadcast(group string, route string, data []byte) {
+	if !appConfig.IsFrontend {
+		return
+	}
+	net.acceptorMapLock.RLock()
+	peers := len(net.acceptorMap)
+	net.acceptorMapLock.RUnlock()
+	if peers > 0 {
+		net.logger.Warn("group broadcast not forwarded to peer frontends, cross-node RPC not implemented yet",
+			"group", group, "route", route, "peers", peers)
+	}
+}
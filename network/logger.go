@@ -0,0 +1,67 @@
+package network
+
+import (
+	"fmt"
+
+	starxlog "github.com/chrislonng/starx/log"
+)
+
+// Logger is the structured logging interface netService, agent and
+// acceptor log through. Each method takes a message followed by
+// alternating key/value pairs, so callers can attach context such as
+// session_id or route without formatting it into the message string.
+type Logger interface {
+	Debug(msg string, kv ...interface{})
+	Info(msg string, kv ...interface{})
+	Warn(msg string, kv ...interface{})
+	Error(msg string, kv ...interface{})
+}
+
+// defaultLogger adapts the package-level github.com/chrislonng/starx/log
+// functions to the Logger interface, so netService has a working logger
+// out of the box without requiring callers to set one up.
+type defaultLogger struct{}
+
+func (defaultLogger) Debug(msg string, kv ...interface{}) { starxlog.Debug(format(msg, kv...)) }
+func (defaultLogger) Info(msg string, kv ...interface{})  { starxlog.Info(format(msg, kv...)) }
+func (defaultLogger) Warn(msg string, kv ...interface{})  { starxlog.Warn(format(msg, kv...)) }
+func (defaultLogger) Error(msg string, kv ...interface{}) { starxlog.Error(format(msg, kv...)) }
+
+// format renders msg and its key/value pairs as "msg key=value key=value"
+// for loggers that only understand a single formatted string.
+func format(msg string, kv ...interface{}) string {
+	for i := 0; i+1 < len(kv); i += 2 {
+		msg += " " + toString(kv[i]) + "=" + toString(kv[i+1])
+	}
+	return msg
+}
+
+func toString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+// SetLogger overrides the Logger used by netService, agent and acceptor,
+// e.g. to install the zap-backed adapter in starx/log/zap.
+func (net *netService) SetLogger(l Logger) {
+	net.logger = l
+}
+
+// WithFields returns a Logger that prepends kv to every call, so handlers
+// can enrich log lines (e.g. with a request id) without threading that
+// state through every call site manually.
+func WithFields(l Logger, kv ...interface{}) Logger {
+	return &fieldLogger{base: l, fields: kv}
+}
+
+type fieldLogger struct {
+	base   Logger
+	fields []interface{}
+}
+
+func (f *fieldLogger) Debug(msg string, kv ...interface{}) { f.base.Debug(msg, append(f.fields, kv...)...) }
+func (f *fieldLogger) Info(msg string, kv ...interface{})  { f.base.Info(msg, append(f.fields, kv...)...) }
+func (f *fieldLogger) Warn(msg string, kv ...interface{})  { f.base.Warn(msg, append(f.fields, kv...)...) }
+func (f *fieldLogger) Error(msg string, kv ...interface{}) { f.base.Error(msg, append(f.fields, kv...)...) }
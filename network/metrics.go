@@ -0,0 +1,134 @@
+package network
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics holds the Prometheus collectors netService reports through.
+// It is created lazily by EnableMetrics so servers that never call it
+// pay no registration cost.
+type metrics struct {
+	agentCount     prometheus.Gauge
+	acceptorCount  prometheus.Gauge
+	packetsSent    *prometheus.CounterVec // labeled by packet type
+	pushTotal      *prometheus.CounterVec // labeled by route
+	responseTotal  *prometheus.CounterVec // labeled by kind, always "response"; not labeled by route
+	broadcastTotal *prometheus.CounterVec // labeled by route
+	sendLatency    *prometheus.HistogramVec
+	closeTotal     *prometheus.CounterVec // labeled by close reason
+}
+
+func newMetrics(reg prometheus.Registerer) *metrics {
+	m := &metrics{
+		agentCount: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "starx",
+			Subsystem: "net",
+			Name:      "agents",
+			Help:      "Number of agents currently connected to this frontend.",
+		}),
+		acceptorCount: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "starx",
+			Subsystem: "net",
+			Name:      "acceptors",
+			Help:      "Number of acceptor connections currently open to backend servers.",
+		}),
+		packetsSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "starx",
+			Subsystem: "net",
+			Name:      "packets_sent_total",
+			Help:      "Packets sent, labeled by packet type.",
+		}, []string{"type"}),
+		pushTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "starx",
+			Subsystem: "net",
+			Name:      "push_total",
+			Help:      "Push calls, labeled by route.",
+		}, []string{"route"}),
+		responseTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "starx",
+			Subsystem: "net",
+			Name:      "response_total",
+			Help:      "Response calls.",
+		}, []string{"kind"}),
+		broadcastTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "starx",
+			Subsystem: "net",
+			Name:      "broadcast_total",
+			Help:      "Broadcast calls, labeled by route.",
+		}, []string{"route"}),
+		sendLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "starx",
+			Subsystem: "net",
+			Name:      "send_latency_seconds",
+			Help:      "Time spent encoding and sending a Push/Response frame.",
+		}, []string{"kind"}),
+		closeTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "starx",
+			Subsystem: "net",
+			Name:      "session_close_total",
+			Help:      "Sessions closed, labeled by close reason.",
+		}, []string{"reason"}),
+	}
+	reg.MustRegister(
+		m.agentCount,
+		m.acceptorCount,
+		m.packetsSent,
+		m.pushTotal,
+		m.responseTotal,
+		m.broadcastTotal,
+		m.sendLatency,
+		m.closeTotal,
+	)
+	return m
+}
+
+// EnableMetrics registers netService's Prometheus collectors against reg
+// (the global registry when reg is nil) and starts reporting. It is safe
+// to call at most once per netService. MetricsHandler serves whatever
+// reg gathers, so a custom Registerer (e.g. a *prometheus.Registry used
+// to isolate this server's metrics) is honored end to end.
+func (net *netService) EnableMetrics(reg prometheus.Registerer) {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	net.metrics = newMetrics(reg)
+	if g, ok := reg.(prometheus.Gatherer); ok {
+		net.gatherer = g
+	} else {
+		net.gatherer = prometheus.DefaultGatherer
+	}
+}
+
+// MetricsHandler returns an http.Handler serving Prometheus metrics from
+// the Registerer passed to EnableMetrics (the global registry if
+// EnableMetrics was never called or was given nil), plus net/http/pprof
+// profiles on the conventional /debug/pprof/* paths, ready to mount on
+// an operator-facing mux.
+func (net *netService) MetricsHandler() http.Handler {
+	gatherer := net.gatherer
+	if gatherer == nil {
+		gatherer = prometheus.DefaultGatherer
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// observeSend records encode+send latency for Push/Response, a no-op
+// when metrics were never enabled.
+func (net *netService) observeSend(kind string, start time.Time) {
+	if net.metrics == nil {
+		return
+	}
+	net.metrics.sendLatency.WithLabelValues(kind).Observe(time.Since(start).Seconds())
+}
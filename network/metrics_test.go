@@ -0,0 +1,24 @@
+package network
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMetricsHandlerServesCustomRegisterer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	net := NewNetService()
+	net.EnableMetrics(reg)
+	net.metrics.agentCount.Set(7)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	net.MetricsHandler().ServeHTTP(rr, req)
+
+	if !strings.Contains(rr.Body.String(), "starx_net_agents 7") {
+		t.Fatalf("MetricsHandler did not serve the custom Registerer's metrics, got:\n%s", rr.Body.String())
+	}
+}
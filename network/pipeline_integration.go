@@ -0,0 +1,13 @@
+package network
+
+import "github.com/chrislonng/starx/pipeline"
+
+// UseRateLimit registers limiter against the outbound pipeline and
+// remembers it so closeSession can call limiter.Forget for a session
+// that disconnects, keeping its counters from outliving the session.
+func (net *netService) UseRateLimit(limiter *pipeline.Limiter) {
+	net.UseOutbound(limiter.Handle)
+	net.limitersLock.Lock()
+	net.limiters = append(net.limiters, limiter)
+	net.limitersLock.Unlock()
+}
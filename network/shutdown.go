@@ -0,0 +1,133 @@
+package network
+
+import (
+	"context"
+
+	"github.com/chrislonng/starx/session"
+)
+
+// CloseReason distinguishes why a session was closed, so sessionCloseCb
+// callbacks and close_total metrics can tell a clean client disconnect
+// apart from a timeout, an admin kick or a server shutdown.
+type CloseReason int
+
+const (
+	ReasonClientDisconnect CloseReason = iota
+	ReasonHeartbeatTimeout
+	ReasonServerShutdown
+	ReasonKicked
+)
+
+func (r CloseReason) String() string {
+	switch r {
+	case ReasonClientDisconnect:
+		return "client_disconnect"
+	case ReasonHeartbeatTimeout:
+		return "heartbeat_timeout"
+	case ReasonServerShutdown:
+		return "server_shutdown"
+	case ReasonKicked:
+		return "kicked"
+	default:
+		return "unknown"
+	}
+}
+
+// Reserved routes used to notify clients of a control event. The original
+// request asked for Shutdown to send a new packet type for "server going
+// away"; packet.Packet (outside this chunk of the tree) has a closed set
+// of Type constants this chunk cannot extend, so Kick and Shutdown both
+// reuse a regular Push frame on one of these reserved routes instead.
+//
+// This is a deviation from the request, not an equivalent substitute:
+// routeShutdown/routeKick look like ordinary application routes on the
+// wire, so a client that does not already know to treat them specially
+// cannot tell them apart from a Push an application handler sent. Flagging
+// this explicitly for maintainer sign-off rather than presenting it as
+// done: if a distinct packet type is a hard requirement, packet.Packet
+// needs a new Type constant added where it is actually defined, outside
+// this chunk.
+const (
+	routeKick     = "sys/kick"
+	routeShutdown = "sys/shutdown"
+)
+
+// Kick force-closes the session identified by sid, reporting reason to
+// the client before closing the connection. Intended for admin
+// operations (banning a player, disconnecting a stale session, ...).
+func (net *netService) Kick(sid uint64, reason string) error {
+	a, err := net.getAgent(sid)
+	if err != nil {
+		return err
+	}
+	if err := net.Push(a.session, routeKick, []byte(reason)); err != nil {
+		return err
+	}
+	net.closeSession(a.session, ReasonKicked)
+	a.conn.Close()
+	return nil
+}
+
+// Shutdown stops netService from accepting new connections, notifies
+// every connected agent that the server is going away, waits up to
+// ctx's deadline for in-flight Push/Response calls to drain, then
+// force-closes whatever agents and acceptors remain.
+func (net *netService) Shutdown(ctx context.Context) error {
+	net.shuttingDownLock.Lock()
+	net.shuttingDown = true
+	net.shuttingDownLock.Unlock()
+
+	// Snapshot the agents under RLock only: closeSession below takes
+	// agentMapLock itself, so we must not still be holding it (or even
+	// holding agentMapLock.RLock, since closeSession needs the write
+	// lock) when we call it.
+	net.agentMapLock.RLock()
+	agents := make([]*agent, 0, len(net.agentMap))
+	for _, a := range net.agentMap {
+		agents = append(agents, a)
+	}
+	net.agentMapLock.RUnlock()
+
+	for _, a := range agents {
+		net.Push(a.session, routeShutdown, nil)
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		net.inFlight.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+	}
+
+	for _, a := range agents {
+		net.closeSession(a.session, ReasonServerShutdown)
+		a.conn.Close()
+	}
+
+	net.acceptorMapLock.Lock()
+	for sid, a := range net.acceptorMap {
+		a.conn.Close()
+		delete(net.acceptorMap, sid)
+	}
+	count := len(net.acceptorMap)
+	net.acceptorMapLock.Unlock()
+	if net.metrics != nil {
+		net.metrics.acceptorCount.Set(float64(count))
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// heartbeatTimeout is called by the agent read loop when a session's
+// heartbeat has expired; split out from closeSession's generic path so
+// the reason reaches metrics and sessionCloseCb.
+func (net *netService) heartbeatTimeout(s *session.Session) {
+	net.closeSession(s, ReasonHeartbeatTimeout)
+}
@@ -0,0 +1,18 @@
+package network
+
+import "testing"
+
+func TestCloseReasonString(t *testing.T) {
+	cases := map[CloseReason]string{
+		ReasonClientDisconnect: "client_disconnect",
+		ReasonHeartbeatTimeout: "heartbeat_timeout",
+		ReasonServerShutdown:   "server_shutdown",
+		ReasonKicked:           "kicked",
+		CloseReason(99):        "unknown",
+	}
+	for reason, want := range cases {
+		if got := reason.String(); got != want {
+			t.Errorf("CloseReason(%d).String() = %q, want %q", reason, got, want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// Package pipeline lets operators enforce policy (rate limiting, size
+// caps, metrics) on every inbound and outbound frame from a single
+// place, instead of patching netService itself.
+//
+// Compression was requested (gzip/snappy negotiated at handshake) and
+// attempted once, but message.Message (outside this chunk of the tree)
+// has no field to flag a payload as compressed, so an inbound stage has
+// no reliable way to tell compressed bytes from plain ones apart before
+// decompressing them; an earlier Compress stage here silently corrupted
+// uncompressed payloads it mistook for compressed and has been removed.
+// Scoped out of this chunk until message.Message carries that flag.
+package pipeline
+
+import (
+	"errors"
+
+	"github.com/chrislonng/starx/message"
+	"github.com/chrislonng/starx/session"
+)
+
+// ErrDropped is the error a Handler returns to drop a frame; Run passes
+// it through unchanged like any other handler error. Callers should treat
+// it as "do not send/process this frame" rather than as a hard failure.
+var ErrDropped = errors.New("pipeline: frame dropped")
+
+// Handler inspects or mutates a message before it is sent or after it is
+// received. Returning an error stops the pipeline; returning ErrDropped
+// drops the frame silently, any other error is propagated to the caller.
+type Handler func(s *session.Session, msg *message.Message) error
+
+// Pipeline is an ordered list of Handlers, applied in registration order.
+type Pipeline struct {
+	handlers []Handler
+}
+
+// New creates an empty Pipeline.
+func New() *Pipeline {
+	return &Pipeline{}
+}
+
+// Use appends handlers to the pipeline.
+func (p *Pipeline) Use(handlers ...Handler) {
+	p.handlers = append(p.handlers, handlers...)
+}
+
+// Run executes every handler in order against s/msg, stopping at the
+// first error.
+func (p *Pipeline) Run(s *session.Session, msg *message.Message) error {
+	for _, h := range p.handlers {
+		if err := h(s, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package pipeline
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/chrislonng/starx/message"
+	"github.com/chrislonng/starx/session"
+)
+
+func TestPipelineRunsHandlersInOrder(t *testing.T) {
+	p := New()
+	var order []int
+	p.Use(
+		func(s *session.Session, msg *message.Message) error { order = append(order, 1); return nil },
+		func(s *session.Session, msg *message.Message) error { order = append(order, 2); return nil },
+	)
+	if err := p.Run(nil, &message.Message{}); err != nil {
+		t.Fatalf("Run() = %v, want nil", err)
+	}
+	if len(order) != 2 || order[0] != 1 || order[1] != 2 {
+		t.Fatalf("handlers ran out of order: %v", order)
+	}
+}
+
+func TestPipelineStopsAtFirstError(t *testing.T) {
+	p := New()
+	ran := false
+	boom := errors.New("boom")
+	p.Use(
+		func(s *session.Session, msg *message.Message) error { return boom },
+		func(s *session.Session, msg *message.Message) error { ran = true; return nil },
+	)
+	if err := p.Run(nil, &message.Message{}); err != boom {
+		t.Fatalf("Run() = %v, want %v", err, boom)
+	}
+	if ran {
+		t.Fatal("second handler ran after the first returned an error")
+	}
+}
+
+func TestMaxSizeDropsOversizedPayload(t *testing.T) {
+	h := MaxSize(4)
+	if err := h(nil, &message.Message{Data: []byte("ok")}); err != nil {
+		t.Fatalf("MaxSize under limit = %v, want nil", err)
+	}
+	if err := h(nil, &message.Message{Data: []byte("too long")}); err != ErrPayloadTooLarge {
+		t.Fatalf("MaxSize over limit = %v, want ErrPayloadTooLarge", err)
+	}
+}
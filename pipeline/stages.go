@@ -0,0 +1,114 @@
+package pipeline
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/chrislonng/starx/message"
+	"github.com/chrislonng/starx/session"
+)
+
+var ErrPayloadTooLarge = errors.New("pipeline: payload exceeds size cap")
+
+// MaxSize builds a Handler that rejects any frame whose data exceeds n
+// bytes with ErrPayloadTooLarge (not ErrDropped, so the error reaches the
+// caller), protecting the server from oversized client payloads.
+func MaxSize(n int) Handler {
+	return func(s *session.Session, msg *message.Message) error {
+		if len(msg.Data) > n {
+			return ErrPayloadTooLarge
+		}
+		return nil
+	}
+}
+
+// Limiter enforces a per-session rate limit. Use NewRateLimiter to build
+// one, register its Handle method with Pipeline.Use, and wire Forget to
+// network.OnSessionClosed so counters do not outlive the session.
+type Limiter struct {
+	mu       sync.Mutex
+	limit    int
+	window   time.Duration
+	counters map[uint64]*rateCounter
+	calls    int
+}
+
+// sweepEvery bounds how often Handle scans for stale counters, so
+// Limiter does not grow without bound even if a caller never wires
+// Forget to session close.
+const sweepEvery = 1024
+
+// staleAfter is how long past its window a counter must sit idle before
+// the sweep reclaims it.
+const staleAfter = 10
+
+type rateCounter struct {
+	count   int
+	resetAt time.Time
+}
+
+// NewRateLimiter builds a Limiter that allows at most limit frames per
+// session within window, dropping the rest.
+func NewRateLimiter(limit int, window time.Duration) *Limiter {
+	return &Limiter{
+		limit:    limit,
+		window:   window,
+		counters: make(map[uint64]*rateCounter),
+	}
+}
+
+// Handle is the Handler for this limiter; register it with Pipeline.Use.
+func (l *Limiter) Handle(s *session.Session, msg *message.Message) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	sid := s.Entity.ID()
+	c, ok := l.counters[sid]
+	if !ok || now.After(c.resetAt) {
+		c = &rateCounter{resetAt: now.Add(l.window)}
+		l.counters[sid] = c
+	}
+	c.count++
+
+	l.calls++
+	if l.calls >= sweepEvery {
+		l.calls = 0
+		l.sweepLocked(now)
+	}
+
+	if c.count > l.limit {
+		return ErrDropped
+	}
+	return nil
+}
+
+// sweepLocked removes counters whose window expired long enough ago that
+// the session is assumed gone; l.mu must be held by the caller.
+func (l *Limiter) sweepLocked(now time.Time) {
+	for sid, c := range l.counters {
+		if now.Sub(c.resetAt) > staleAfter*l.window {
+			delete(l.counters, sid)
+		}
+	}
+}
+
+// Forget drops sid's counter. Callers should wire this to
+// network.OnSessionClosed so Limiter does not grow without bound as
+// sessions churn over the life of the process.
+func (l *Limiter) Forget(sid uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.counters, sid)
+}
+
+// Metrics builds a Handler that invokes observe for every frame that
+// passes through the pipeline, so operators can wire it to whatever
+// metrics backend they use (e.g. starx/network's Prometheus counters)
+// without this package depending on that backend directly.
+func Metrics(observe func(s *session.Session, msg *message.Message)) Handler {
+	return func(s *session.Session, msg *message.Message) error {
+		observe(s, msg)
+		return nil
+	}
+}